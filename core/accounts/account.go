@@ -0,0 +1,120 @@
+// Package accounts provides the key material and control-program
+// bookkeeping behind chain/core/asset's AccountReserver and
+// AccountReceiver.
+package accounts
+
+import (
+	"golang.org/x/net/context"
+
+	"chain/cos/txscript"
+	"chain/crypto/ed25519/hd25519"
+	"chain/database/pg"
+	"chain/errors"
+)
+
+// ScriptType selects the shape of control program an account's
+// outputs use. ScriptTypeWitnessPubKey only applies when the account
+// is 1-of-1 (Quorum == 1 and len(XPubs) == 1); accounts with a higher
+// quorum always use ScriptTypeMultisig regardless of this field.
+type ScriptType string
+
+const (
+	ScriptTypeMultisig      ScriptType = "multisig"
+	ScriptTypeWitnessPubKey ScriptType = "witness_pubkey"
+)
+
+// Account is the set of keys and the quorum needed to spend from
+// control programs created for it.
+type Account struct {
+	ID         string
+	XPubs      []hd25519.XPub
+	Quorum     int
+	ScriptType ScriptType
+}
+
+// Find looks up the account with the given id.
+func Find(ctx context.Context, id string) (*Account, error) {
+	const q = `
+		SELECT id, xpubs, quorum, script_type
+		FROM accounts
+		WHERE id = $1
+	`
+	var a Account
+	err := pg.QueryRow(ctx, q, id).Scan(&a.ID, &a.XPubs, &a.Quorum, &a.ScriptType)
+	if err != nil {
+		return nil, errors.Wrap(err, "querying account")
+	}
+	return &a, nil
+}
+
+// CreateControlProgram returns a new control program controlled by
+// accountID, recording its derivation path so that future spends can
+// be recognized and signed for.
+func CreateControlProgram(ctx context.Context, accountID string) ([]byte, error) {
+	account, err := Find(ctx, accountID)
+	if err != nil {
+		return nil, err
+	}
+
+	idx, err := nextControlProgramIndex(ctx, account.ID)
+	if err != nil {
+		return nil, errors.Wrap(err, "assigning control program index")
+	}
+
+	derivedPKs := hd25519.XPubKeys(hd25519.DeriveXPubs(account.XPubs, uint32PathElems(idx[:])))
+
+	var program []byte
+	if account.ScriptType == ScriptTypeWitnessPubKey && account.Quorum == 1 && len(derivedPKs) == 1 {
+		program, err = txscript.WitnessPubKeyScript(derivedPKs[0])
+	} else {
+		program, err = txscript.MultiSigScript(derivedPKs, account.Quorum)
+	}
+	if err != nil {
+		return nil, errors.Wrap(err, "computing control program")
+	}
+
+	err = insertControlProgram(ctx, account.ID, idx, program)
+	if err != nil {
+		return nil, errors.Wrap(err, "recording control program")
+	}
+	return program, nil
+}
+
+// uint32PathElems renders each element of idx as a big-endian
+// derivation-path component.
+func uint32PathElems(idx []uint32) [][]byte {
+	elems := make([][]byte, len(idx))
+	for i, n := range idx {
+		elems[i] = []byte{byte(n >> 24), byte(n >> 16), byte(n >> 8), byte(n)}
+	}
+	return elems
+}
+
+// nextControlProgramIndex assigns the next unused key index for
+// accountID, atomically incrementing the account's counter.
+func nextControlProgramIndex(ctx context.Context, accountID string) (idx [2]uint32, err error) {
+	const q = `
+		UPDATE accounts SET key_index = key_index + 1
+		WHERE id = $1
+		RETURNING key_index
+	`
+	var keyIndex uint32
+	err = pg.QueryRow(ctx, q, accountID).Scan(&keyIndex)
+	if err != nil {
+		return idx, err
+	}
+	idx[1] = keyIndex
+	return idx, nil
+}
+
+// insertControlProgram records the mapping from a derived control
+// program back to the account and key index that produced it, so
+// that LoadAccountInfo and friends can recognize it later.
+func insertControlProgram(ctx context.Context, accountID string, idx [2]uint32, program []byte) error {
+	const q = `
+		INSERT INTO account_control_programs (signer_id, key_index, control_program)
+		VALUES ($1, to_key_index($2, $3), $4)
+	`
+	_, err := pg.Exec(ctx, q, accountID, idx[0], idx[1], program)
+	return err
+}
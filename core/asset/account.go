@@ -1,6 +1,7 @@
 package asset
 
 import (
+	"fmt"
 	"math/rand"
 	"time"
 
@@ -17,16 +18,68 @@ import (
 	"chain/database/pg"
 	"chain/errors"
 	"chain/net/trace/span"
+	"chain/sync/idempotency"
 )
 
+// reserveIdempotencyTTL bounds how long a completed reservation
+// result stays cached under its ClientToken. A retry within this
+// window gets back the original result instead of reserving new
+// coins; a retry after it has elapsed is treated as a fresh request.
+const reserveIdempotencyTTL = 24 * time.Hour
+
+// reserveIdempotencyCapacity bounds the number of completed results
+// idempotencyGroup keeps around at once, evicting the oldest first.
+const reserveIdempotencyCapacity = 10000
+
+var idempotencyGroup = idempotency.New(reserveIdempotencyTTL, reserveIdempotencyCapacity)
+
 type AccountReserver struct {
-	AccountID   string
-	TxHash      *bc.Hash // optional filter
-	OutputIndex *uint32  // optional filter
-	ClientToken *string
+	AccountID    string
+	TxHash       *bc.Hash // optional filter
+	OutputIndex  *uint32  // optional filter
+	ClientToken  *string
+	CoinSelector utxodb.CoinSelector // optional; nil keeps utxodb's own selection
+}
+
+func (reserver *AccountReserver) Reserve(ctx context.Context, assetAmount *bc.AssetAmount, ttl time.Duration) (result *txbuilder.ReserveResult, err error) {
+	ctx, finish := withRollbackScope(ctx)
+	defer finish(&err)
+
+	if reserver.ClientToken == nil {
+		return reserver.reserve(ctx, assetAmount, ttl)
+	}
+
+	key := reserveIdempotencyKey(reserver.AccountID, assetAmount, *reserver.ClientToken, reserver.TxHash, reserver.OutputIndex)
+	val, err, _ := idempotencyGroup.Do(key, func() (interface{}, error) {
+		return reserver.reserve(ctx, assetAmount, ttl)
+	})
+	if err != nil {
+		return nil, err
+	}
+	return val.(*txbuilder.ReserveResult), nil
 }
 
-func (reserver *AccountReserver) Reserve(ctx context.Context, assetAmount *bc.AssetAmount, ttl time.Duration) (*txbuilder.ReserveResult, error) {
+// reserveIdempotencyKey identifies a reservation request for
+// deduplication purposes. Requests that agree on account, asset,
+// amount, and token are assumed to be retries of the same logical
+// reservation, unless the reserver also pins a specific outpoint, in
+// which case that outpoint is folded into the key too: two requests
+// that share a ClientToken but target different outpoints must not
+// collapse onto the same cache entry and hand one caller back the
+// other's reservation.
+func reserveIdempotencyKey(accountID string, assetAmount *bc.AssetAmount, clientToken string, txHash *bc.Hash, outputIndex *uint32) string {
+	key := fmt.Sprintf("%s\x00%s\x00%d\x00%s", accountID, assetAmount.AssetID, assetAmount.Amount, clientToken)
+	if txHash != nil && outputIndex != nil {
+		key += fmt.Sprintf("\x00%s\x00%d", *txHash, *outputIndex)
+	}
+	return key
+}
+
+func (reserver *AccountReserver) reserve(ctx context.Context, assetAmount *bc.AssetAmount, ttl time.Duration) (*txbuilder.ReserveResult, error) {
+	if reserver.CoinSelector != nil && reserver.TxHash == nil && reserver.OutputIndex == nil {
+		return reserver.reserveSelected(ctx, assetAmount, ttl)
+	}
+
 	utxodbSource := utxodb.Source{
 		AssetID:     assetAmount.AssetID,
 		Amount:      assetAmount.Amount,
@@ -41,34 +94,18 @@ func (reserver *AccountReserver) Reserve(ctx context.Context, assetAmount *bc.As
 		return nil, err
 	}
 
-	result := &txbuilder.ReserveResult{}
+	var outpoints []bc.Outpoint
 	for _, r := range reserved {
-		txInput := bc.NewSpendInput(r.Hash, r.Index, nil, r.AssetID, r.Amount, r.Script, nil)
-
-		templateInput := &txbuilder.Input{}
-		inputAccount, err := accounts.Find(ctx, r.AccountID)
-		if err != nil {
-			return nil, errors.Wrap(err, "get account info")
-		}
-
-		path := signers.Path(inputAccount, signers.AccountKeySpace, r.ControlProgramIndex[:])
-		derivedXPubs := hd25519.DeriveXPubs(inputAccount.XPubs, path)
-		derivedPKs := hd25519.XPubKeys(derivedXPubs)
+		outpoints = append(outpoints, bc.Outpoint{Hash: r.Hash, Index: r.Index})
+	}
+	registerRollback(ctx, outpoints)
 
-		redeemScript, err := txscript.MultiSigScript(derivedPKs, inputAccount.Quorum)
+	result := &txbuilder.ReserveResult{}
+	for _, r := range reserved {
+		item, err := reservedToItem(ctx, r.AssetID, r.Amount, r.Hash, r.Index, r.Script, r.AccountID, r.ControlProgramIndex)
 		if err != nil {
-			return nil, errors.Wrap(err, "compute redeem script")
-		}
-		templateInput.AssetID = r.AssetID
-		templateInput.Amount = r.Amount
-		templateInput.AddWitnessSigs(txbuilder.InputSigs(inputAccount.XPubs, path), inputAccount.Quorum, nil)
-		templateInput.AddWitnessData(redeemScript)
-
-		item := &txbuilder.ReserveResultItem{
-			TxInput:       txInput,
-			TemplateInput: templateInput,
+			return nil, err
 		}
-
 		result.Items = append(result.Items, item)
 	}
 	if len(change) > 0 {
@@ -90,6 +127,114 @@ func (reserver *AccountReserver) Reserve(ctx context.Context, assetAmount *bc.As
 	return result, nil
 }
 
+// reserveSelected runs reserver.CoinSelector over the account's
+// candidate UTXO set and reserves exactly the outputs it chooses,
+// instead of leaving selection up to utxodb.Reserve.
+func (reserver *AccountReserver) reserveSelected(ctx context.Context, assetAmount *bc.AssetAmount, ttl time.Duration) (*txbuilder.ReserveResult, error) {
+	candidates, err := utxodb.Candidates(ctx, assetAmount.AssetID, reserver.AccountID)
+	if err != nil {
+		return nil, errors.Wrap(err, "loading utxo candidates")
+	}
+
+	selected, changeAmount := reserver.CoinSelector.Select(candidates, assetAmount.Amount)
+	if len(selected) == 0 {
+		return nil, errors.New("coin selection: insufficient funds")
+	}
+
+	// Reserve every selected outpoint in a single utxodb.Reserve call.
+	// Issuing one call per outpoint would pass reserver.ClientToken to
+	// each of them, and utxodb's own idempotency dedup (keyed in part
+	// on that token) would then see calls 2..N as retries of call 1
+	// and hand back call 1's outpoint instead of reserving the rest.
+	utxodbSources := make([]utxodb.Source, len(selected))
+	for i, u := range selected {
+		hash, index := u.Hash, u.Index
+		utxodbSources[i] = utxodb.Source{
+			AssetID:     u.AssetID,
+			Amount:      u.Amount,
+			AccountID:   reserver.AccountID,
+			TxHash:      &hash,
+			OutputIndex: &index,
+			ClientToken: reserver.ClientToken,
+		}
+	}
+	reserved, _, err := utxodb.Reserve(ctx, utxodbSources, ttl)
+	if err != nil {
+		return nil, errors.Wrap(err, "reserving selected utxos")
+	}
+
+	var outpoints []bc.Outpoint
+	for _, r := range reserved {
+		outpoints = append(outpoints, bc.Outpoint{Hash: r.Hash, Index: r.Index})
+	}
+	registerRollback(ctx, outpoints)
+
+	result := &txbuilder.ReserveResult{}
+	for _, r := range reserved {
+		item, err := reservedToItem(ctx, r.AssetID, r.Amount, r.Hash, r.Index, r.Script, r.AccountID, r.ControlProgramIndex)
+		if err != nil {
+			return nil, err
+		}
+		result.Items = append(result.Items, item)
+	}
+
+	if changeAmount > 0 {
+		changeAmounts := breakupChange(changeAmount)
+		for _, amt := range changeAmounts {
+			dest, err := NewAccountDestination(ctx, &bc.AssetAmount{AssetID: assetAmount.AssetID, Amount: amt}, reserver.AccountID, nil)
+			if err != nil {
+				return nil, errors.Wrap(err, "creating change destination")
+			}
+			result.Change = append(result.Change, dest)
+		}
+	}
+
+	return result, nil
+}
+
+// reservedToItem derives the signing template for a single reserved
+// UTXO, shared by every Reserver that ends up pinning a specific
+// outpoint through utxodb.Reserve.
+func reservedToItem(ctx context.Context, assetID bc.AssetID, amount uint64, hash bc.Hash, index uint32, script []byte, accountID string, controlProgramIndex [2]uint32) (*txbuilder.ReserveResultItem, error) {
+	txInput := bc.NewSpendInput(hash, index, nil, assetID, amount, script, nil)
+
+	templateInput := &txbuilder.Input{}
+	inputAccount, err := accounts.Find(ctx, accountID)
+	if err != nil {
+		return nil, errors.Wrap(err, "get account info")
+	}
+
+	path := signers.Path(inputAccount, signers.AccountKeySpace, controlProgramIndex[:])
+	derivedXPubs := hd25519.DeriveXPubs(inputAccount.XPubs, path)
+	derivedPKs := hd25519.XPubKeys(derivedXPubs)
+
+	templateInput.AssetID = assetID
+	templateInput.Amount = amount
+
+	if inputAccount.ScriptType == accounts.ScriptTypeWitnessPubKey && inputAccount.Quorum == 1 && len(derivedPKs) == 1 {
+		// Compact 1-of-1 case: the control program commits only to
+		// SHA-256(pubkey), not the pubkey itself, so the witness must
+		// carry the pubkey preimage alongside the signature for a
+		// verifier to check it against the commitment and run
+		// ed25519.Verify, which takes the pubkey as an explicit
+		// argument.
+		templateInput.AddWitnessSigs(txbuilder.InputSigs(inputAccount.XPubs, path), 1, nil)
+		templateInput.AddWitnessData(derivedPKs[0])
+	} else {
+		redeemScript, err := txscript.MultiSigScript(derivedPKs, inputAccount.Quorum)
+		if err != nil {
+			return nil, errors.Wrap(err, "compute redeem script")
+		}
+		templateInput.AddWitnessSigs(txbuilder.InputSigs(inputAccount.XPubs, path), inputAccount.Quorum, nil)
+		templateInput.AddWitnessData(redeemScript)
+	}
+
+	return &txbuilder.ReserveResultItem{
+		TxInput:       txInput,
+		TemplateInput: templateInput,
+	}, nil
+}
+
 func breakupChange(total uint64) (amounts []uint64) {
 	for total > 1 && rand.Intn(2) == 0 {
 		thisChange := 1 + uint64(rand.Int63n(int64(total)))
@@ -102,15 +247,33 @@ func breakupChange(total uint64) (amounts []uint64) {
 	return amounts
 }
 
-func NewAccountSource(ctx context.Context, assetAmount *bc.AssetAmount, accountID string, txHash *bc.Hash, outputIndex *uint32, clientToken *string) *txbuilder.Source {
+// AccountSourceOption configures an AccountReserver returned by
+// NewAccountSource.
+type AccountSourceOption func(*AccountReserver)
+
+// WithCoinSelector makes NewAccountSource reserve by running cs over
+// the account's candidate UTXO set instead of leaving selection up
+// to utxodb.Reserve. It has no effect when NewAccountSource is also
+// given a txHash or outputIndex filter.
+func WithCoinSelector(cs utxodb.CoinSelector) AccountSourceOption {
+	return func(reserver *AccountReserver) {
+		reserver.CoinSelector = cs
+	}
+}
+
+func NewAccountSource(ctx context.Context, assetAmount *bc.AssetAmount, accountID string, txHash *bc.Hash, outputIndex *uint32, clientToken *string, opts ...AccountSourceOption) *txbuilder.Source {
+	reserver := &AccountReserver{
+		AccountID:   accountID,
+		TxHash:      txHash,
+		OutputIndex: outputIndex,
+		ClientToken: clientToken,
+	}
+	for _, opt := range opts {
+		opt(reserver)
+	}
 	return &txbuilder.Source{
 		AssetAmount: *assetAmount,
-		Reserver: &AccountReserver{
-			AccountID:   accountID,
-			TxHash:      txHash,
-			OutputIndex: outputIndex,
-			ClientToken: clientToken,
-		},
+		Reserver:    reserver,
 	}
 }
 
@@ -144,6 +307,46 @@ func CancelReservations(ctx context.Context, outpoints []bc.Outpoint) error {
 	return utxodb.Cancel(ctx, outpoints)
 }
 
+// withRollbackScope makes sure ctx carries a txbuilder.TemplateBuilder,
+// so that registerRollback has somewhere to register a cancellation
+// callback even when the caller is reserving outside of a larger,
+// multi-source build. If ctx already carries one (because Reserve is
+// being called as one step of such a build), it's reused as is and
+// finish is a no-op: the outer build owns rolling it back, and doing
+// so again here would re-run every rollback registered so far by
+// other sources in the same build. Otherwise, finish aborts the
+// builder — releasing whatever this call reserved — when called with
+// a non-nil error, which the caller should do via a deferred call
+// with its own named error return.
+func withRollbackScope(ctx context.Context) (context.Context, func(*error)) {
+	if _, ok := txbuilder.FromContext(ctx); ok {
+		return ctx, func(*error) {}
+	}
+	b := new(txbuilder.TemplateBuilder)
+	ctx = txbuilder.NewContext(ctx, b)
+	return ctx, func(errp *error) {
+		if *errp != nil {
+			b.Abort()
+		}
+	}
+}
+
+// registerRollback arranges for outpoints to be freed if the build
+// in progress on ctx fails or is aborted. Reserve always makes sure
+// ctx carries a txbuilder.TemplateBuilder (see withRollbackScope), so
+// in practice this only no-ops if reserve/reserveSelected are called
+// directly, bypassing Reserve.
+func registerRollback(ctx context.Context, outpoints []bc.Outpoint) {
+	if len(outpoints) == 0 {
+		return
+	}
+	b, ok := txbuilder.FromContext(ctx)
+	if !ok {
+		return
+	}
+	b.OnRollback(func() { CancelReservations(ctx, outpoints) })
+}
+
 // LoadAccountInfo turns a set of state.Outputs into a set of
 // outputs by adding account annotations.  Outputs that can't be
 // annotated are excluded from the result.
@@ -0,0 +1,199 @@
+package asset
+
+import (
+	"crypto/sha256"
+	"math/big"
+
+	"golang.org/x/net/context"
+
+	"chain/cos/bc"
+	"chain/cos/state"
+	"chain/cos/txscript"
+	"chain/database/pg"
+	"chain/errors"
+	"chain/net/trace/span"
+)
+
+// AnnotatedOutput is a state.Output enriched with everything a
+// wallet UI or block explorer typically wants to show alongside it:
+// the owning account (if any), the asset's human-readable alias and
+// definition, the output's address, and whether it represents change
+// back to an account that also funded the transaction.
+type AnnotatedOutput struct {
+	state.Output
+	AccountID       string
+	AssetAlias      string
+	AssetDefinition []byte
+	Address         string
+	Change          bool
+}
+
+// LoadAnnotatedOutputs annotates outs with account, asset, address,
+// and change information. Unlike LoadAccountInfo, outputs that can't
+// be matched to an account are still included in the result, just
+// with AccountID left blank. tx provides the transaction the outputs
+// belong to, so that change can be detected by checking whether any
+// of its inputs are controlled by the same account.
+func LoadAnnotatedOutputs(ctx context.Context, tx *bc.Tx, outs []*state.Output) ([]*AnnotatedOutput, error) {
+	ctx = span.NewContext(ctx)
+	defer span.Finish(ctx)
+
+	changeAccounts, err := fundingAccounts(ctx, tx)
+	if err != nil {
+		return nil, errors.Wrap(err, "loading funding accounts")
+	}
+
+	result := make([]*AnnotatedOutput, 0, len(outs))
+	byScript := make(map[string][]*AnnotatedOutput, len(outs))
+	byAsset := make(map[bc.AssetID][]*AnnotatedOutput, len(outs))
+	var scripts, assetIDs pg.Byteas
+	seenAsset := make(map[bc.AssetID]bool, len(outs))
+
+	for _, out := range outs {
+		address, err := addressFromControlProgram(out.ControlProgram)
+		if err != nil {
+			return nil, errors.Wrap(err, "decoding address")
+		}
+
+		ao := &AnnotatedOutput{Output: *out, Address: address}
+		result = append(result, ao)
+
+		scriptStr := string(out.ControlProgram)
+		if _, ok := byScript[scriptStr]; !ok {
+			scripts = append(scripts, out.ControlProgram)
+		}
+		byScript[scriptStr] = append(byScript[scriptStr], ao)
+
+		byAsset[out.AssetID] = append(byAsset[out.AssetID], ao)
+		if !seenAsset[out.AssetID] {
+			seenAsset[out.AssetID] = true
+			id := out.AssetID
+			assetIDs = append(assetIDs, id[:])
+		}
+	}
+
+	const acpQ = `
+		SELECT signer_id, control_program
+		FROM account_control_programs
+		WHERE control_program IN (SELECT unnest($1::bytea[]))
+	`
+	err = pg.ForQueryRows(ctx, acpQ, scripts, func(accountID string, program []byte) {
+		for _, ao := range byScript[string(program)] {
+			ao.AccountID = accountID
+			ao.Change = changeAccounts[accountID]
+		}
+	})
+	if err != nil {
+		return nil, errors.Wrap(err, "loading owning accounts")
+	}
+
+	const assetQ = `
+		SELECT id, alias, definition
+		FROM assets
+		WHERE id IN (SELECT unnest($1::bytea[]))
+	`
+	err = pg.ForQueryRows(ctx, assetQ, assetIDs, func(assetID bc.AssetID, alias string, definition []byte) {
+		for _, ao := range byAsset[assetID] {
+			ao.AssetAlias = alias
+			ao.AssetDefinition = definition
+		}
+	})
+	if err != nil {
+		return nil, errors.Wrap(err, "loading asset aliases")
+	}
+
+	return result, nil
+}
+
+// fundingAccounts returns the set of account IDs that control one of
+// tx's inputs, used to recognize an output as change.
+func fundingAccounts(ctx context.Context, tx *bc.Tx) (map[string]bool, error) {
+	var scripts pg.Byteas
+	for _, in := range tx.Inputs {
+		scripts = append(scripts, in.PrevScript)
+	}
+	if len(scripts) == 0 {
+		return nil, nil
+	}
+
+	accounts := make(map[string]bool)
+	const q = `
+		SELECT DISTINCT signer_id
+		FROM account_control_programs
+		WHERE control_program IN (SELECT unnest($1::bytea[]))
+	`
+	err := pg.ForQueryRows(ctx, q, scripts, func(accountID string) {
+		accounts[accountID] = true
+	})
+	if err != nil {
+		return nil, err
+	}
+	return accounts, nil
+}
+
+// Standard script opcodes, used only to recognize the handful of
+// control-program shapes addressFromControlProgram knows how to
+// decode.
+const (
+	opDup            = 0x76
+	opHash160        = 0xa9
+	opEqualVerify    = 0x88
+	opEqual          = 0x87
+	opCheckSig       = 0xac
+	opCheckMultiSig  = 0xae
+	opDataPush20     = 0x14
+	addressVersionP2 = 0x00 // pubkey-hash version byte
+	addressVersionSH = 0x05 // script-hash version byte
+	addressVersionWP = 0x06 // witness-pubkey version byte
+)
+
+// addressFromControlProgram decodes the on-chain address encoded by
+// a P2PKH, P2SH, or witness-pubkey control program. Multisig control
+// programs (bare m-of-n, not wrapped in P2SH) have no single address
+// to report and decode to "".
+func addressFromControlProgram(program []byte) (string, error) {
+	switch {
+	case len(program) == 25 && program[0] == opDup && program[1] == opHash160 && program[2] == opDataPush20 && program[23] == opEqualVerify && program[24] == opCheckSig:
+		return base58CheckEncode(addressVersionP2, program[3:23]), nil
+	case len(program) == 23 && program[0] == opHash160 && program[1] == opDataPush20 && program[22] == opEqual:
+		return base58CheckEncode(addressVersionSH, program[2:22]), nil
+	case txscript.IsWitnessPubKeyScript(program):
+		return base58CheckEncode(addressVersionWP, program[2:]), nil
+	default:
+		return "", nil
+	}
+}
+
+const base58Alphabet = "123456789ABCDEFGHJKLMNPQRSTUVWXYZabcdefghijkmnopqrstuvwxyz"
+
+// base58CheckEncode implements the standard Base58Check encoding
+// (version byte + payload + 4-byte double-SHA256 checksum) used to
+// render P2PKH and P2SH addresses.
+func base58CheckEncode(version byte, payload []byte) string {
+	buf := append([]byte{version}, payload...)
+	checksum := doubleSHA256(buf)
+	buf = append(buf, checksum[:4]...)
+
+	n := new(big.Int).SetBytes(buf)
+	base := big.NewInt(58)
+	zero := big.NewInt(0)
+	mod := new(big.Int)
+
+	var encoded []byte
+	for n.Cmp(zero) > 0 {
+		n.DivMod(n, base, mod)
+		encoded = append([]byte{base58Alphabet[mod.Int64()]}, encoded...)
+	}
+	for _, b := range buf {
+		if b != 0 {
+			break
+		}
+		encoded = append([]byte{base58Alphabet[0]}, encoded...)
+	}
+	return string(encoded)
+}
+
+func doubleSHA256(b []byte) [32]byte {
+	first := sha256.Sum256(b)
+	return sha256.Sum256(first[:])
+}
@@ -0,0 +1,101 @@
+package asset
+
+import (
+	"bytes"
+	"testing"
+
+	"chain/cos/txscript"
+	"chain/crypto/ed25519"
+)
+
+func TestAddressFromControlProgramP2PKH(t *testing.T) {
+	hash := bytes.Repeat([]byte{0x11}, 20)
+	program := append([]byte{opDup, opHash160, opDataPush20}, hash...)
+	program = append(program, opEqualVerify, opCheckSig)
+
+	addr, err := addressFromControlProgram(program)
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	if want := base58CheckEncode(addressVersionP2, hash); addr != want {
+		t.Errorf("addr = %q, want %q", addr, want)
+	}
+}
+
+func TestAddressFromControlProgramP2SH(t *testing.T) {
+	hash := bytes.Repeat([]byte{0x22}, 20)
+	program := append([]byte{opHash160, opDataPush20}, hash...)
+	program = append(program, opEqual)
+
+	addr, err := addressFromControlProgram(program)
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	if want := base58CheckEncode(addressVersionSH, hash); addr != want {
+		t.Errorf("addr = %q, want %q", addr, want)
+	}
+}
+
+func TestAddressFromControlProgramWitnessPubKey(t *testing.T) {
+	pubkey := ed25519.PublicKey(bytes.Repeat([]byte{0x33}, ed25519.PublicKeySize))
+	program, err := txscript.WitnessPubKeyScript(pubkey)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	addr, err := addressFromControlProgram(program)
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	if want := base58CheckEncode(addressVersionWP, program[2:]); addr != want {
+		t.Errorf("addr = %q, want %q", addr, want)
+	}
+	if addr == "" {
+		t.Error("addr = \"\", want a decoded witness-pubkey address")
+	}
+}
+
+func TestAddressFromControlProgramUnrecognized(t *testing.T) {
+	// A bare multisig script has no single address to report.
+	program := []byte{0x52, 0x21, 0xaa, 0xae}
+
+	addr, err := addressFromControlProgram(program)
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	if addr != "" {
+		t.Errorf("addr = %q, want \"\" for an unrecognized program", addr)
+	}
+}
+
+func TestBase58CheckEncodeLeadingZeroes(t *testing.T) {
+	// A payload of all zero bytes, combined with the pubkey-hash
+	// version byte (also zero), must preserve one leading '1' per
+	// leading zero byte rather than collapsing them away.
+	payload := make([]byte, 20)
+
+	addr := base58CheckEncode(addressVersionP2, payload)
+	if len(addr) <= 21 {
+		t.Fatalf("len(addr) = %d, want more than 21 (version byte + 20 zero payload bytes plus checksum)", len(addr))
+	}
+	for i := 0; i < 21; i++ { // version byte + 20 zero payload bytes
+		if addr[i] != base58Alphabet[0] {
+			t.Fatalf("addr[%d] = %q, want leading %q for each leading zero byte", i, addr[i], base58Alphabet[0])
+		}
+	}
+}
+
+func TestBase58CheckEncodeDeterministic(t *testing.T) {
+	payload := bytes.Repeat([]byte{0x44}, 20)
+
+	a := base58CheckEncode(addressVersionP2, payload)
+	b := base58CheckEncode(addressVersionP2, payload)
+	if a != b {
+		t.Errorf("base58CheckEncode not deterministic: %q != %q", a, b)
+	}
+
+	c := base58CheckEncode(addressVersionSH, payload)
+	if a == c {
+		t.Errorf("encodings with different versions collided: %q", a)
+	}
+}
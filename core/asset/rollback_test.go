@@ -0,0 +1,79 @@
+package asset
+
+import (
+	"errors"
+	"testing"
+
+	"golang.org/x/net/context"
+
+	"chain/core/txbuilder"
+)
+
+// These exercise withRollbackScope directly, the plumbing Reserve
+// relies on to make sure registerRollback always has a builder to
+// register against. A test that reserves a real outpoint through
+// AccountReserver and asserts it's free again after a failed build
+// needs a database, which this package's test suite doesn't set up;
+// that belongs alongside a future DB-backed test harness for this
+// package.
+func TestWithRollbackScopeAbortsOnError(t *testing.T) {
+	ctx, finish := withRollbackScope(context.Background())
+
+	b, ok := txbuilder.FromContext(ctx)
+	if !ok {
+		t.Fatal("withRollbackScope did not attach a TemplateBuilder to ctx")
+	}
+
+	var rolledBack bool
+	b.OnRollback(func() { rolledBack = true })
+
+	err := errors.New("reservation failed")
+	finish(&err)
+
+	if !rolledBack {
+		t.Error("finish did not roll back the builder it created after an error")
+	}
+}
+
+func TestWithRollbackScopeNoopOnSuccess(t *testing.T) {
+	ctx, finish := withRollbackScope(context.Background())
+
+	b, ok := txbuilder.FromContext(ctx)
+	if !ok {
+		t.Fatal("withRollbackScope did not attach a TemplateBuilder to ctx")
+	}
+
+	var rolledBack bool
+	b.OnRollback(func() { rolledBack = true })
+
+	var err error
+	finish(&err)
+
+	if rolledBack {
+		t.Error("finish rolled back the builder despite a nil error")
+	}
+}
+
+func TestWithRollbackScopeReusesExistingBuilder(t *testing.T) {
+	outer := new(txbuilder.TemplateBuilder)
+	ctx := txbuilder.NewContext(context.Background(), outer)
+
+	ctx, finish := withRollbackScope(ctx)
+
+	inner, ok := txbuilder.FromContext(ctx)
+	if !ok || inner != outer {
+		t.Fatal("withRollbackScope replaced an existing builder instead of reusing it")
+	}
+
+	var rolledBack bool
+	outer.OnRollback(func() { rolledBack = true })
+
+	// finish must be a no-op here: the outer build, not this call,
+	// owns deciding whether outer gets rolled back.
+	err := errors.New("one source among several failed")
+	finish(&err)
+
+	if rolledBack {
+		t.Error("finish rolled back a builder it did not create")
+	}
+}
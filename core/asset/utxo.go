@@ -0,0 +1,116 @@
+package asset
+
+import (
+	"time"
+
+	"golang.org/x/net/context"
+
+	"chain/core/txbuilder"
+	"chain/core/utxodb"
+	"chain/cos/bc"
+	"chain/database/pg"
+	"chain/errors"
+)
+
+// UTXOReserver reserves a single UTXO identified by its outpoint,
+// without the caller naming the account that owns it: the owning
+// account is looked up from the output's control program. It's the
+// reserver behind NewUTXOSource.
+type UTXOReserver struct {
+	Outpoint    bc.Outpoint
+	ClientToken *string
+}
+
+func (reserver *UTXOReserver) Reserve(ctx context.Context, assetAmount *bc.AssetAmount, ttl time.Duration) (result *txbuilder.ReserveResult, err error) {
+	ctx, finish := withRollbackScope(ctx)
+	defer finish(&err)
+
+	utxodbSource := utxodb.Source{
+		AssetID:     assetAmount.AssetID,
+		Amount:      assetAmount.Amount,
+		TxHash:      &reserver.Outpoint.Hash,
+		OutputIndex: &reserver.Outpoint.Index,
+		ClientToken: reserver.ClientToken,
+	}
+	reserved, _, err := utxodb.Reserve(ctx, []utxodb.Source{utxodbSource}, ttl)
+	if err != nil {
+		return nil, err
+	}
+
+	var outpoints []bc.Outpoint
+	for _, r := range reserved {
+		outpoints = append(outpoints, bc.Outpoint{Hash: r.Hash, Index: r.Index})
+	}
+	registerRollback(ctx, outpoints)
+
+	result = &txbuilder.ReserveResult{}
+	for _, r := range reserved {
+		accountID, keyIndex, err := controllingAccount(ctx, r.Script)
+		if err != nil {
+			return nil, errors.Wrap(err, "finding controlling account")
+		}
+
+		item, err := reservedToItem(ctx, r.AssetID, r.Amount, r.Hash, r.Index, r.Script, accountID, keyIndex)
+		if err != nil {
+			return nil, err
+		}
+		result.Items = append(result.Items, item)
+	}
+	return result, nil
+}
+
+// NewUTXOSource builds a txbuilder.Source that spends exactly the
+// output at outpoint, without the caller naming the account that
+// controls it. Reserving fails if no account's control program
+// matches the output. The asset and amount are read from the output itself
+// rather than supplied by the caller, since a caller asking to spend
+// a specific outpoint may not know them in advance.
+func NewUTXOSource(ctx context.Context, outpoint bc.Outpoint, clientToken *string) (*txbuilder.Source, error) {
+	assetAmount, err := loadOutpointAssetAmount(ctx, outpoint)
+	if err != nil {
+		return nil, errors.Wrap(err, "loading outpoint")
+	}
+
+	return &txbuilder.Source{
+		AssetAmount: *assetAmount,
+		Reserver: &UTXOReserver{
+			Outpoint:    outpoint,
+			ClientToken: clientToken,
+		},
+	}, nil
+}
+
+// loadOutpointAssetAmount looks up the asset and amount of the
+// output at outpoint directly from state, so that NewUTXOSource's
+// caller need only name the outpoint.
+func loadOutpointAssetAmount(ctx context.Context, outpoint bc.Outpoint) (*bc.AssetAmount, error) {
+	const q = `
+		SELECT asset_id, amount
+		FROM utxos
+		WHERE tx_hash = $1 AND index = $2
+	`
+	var assetAmount bc.AssetAmount
+	err := pg.QueryRow(ctx, q, outpoint.Hash, outpoint.Index).Scan(&assetAmount.AssetID, &assetAmount.Amount)
+	if err != nil {
+		return nil, err
+	}
+	return &assetAmount, nil
+}
+
+// controllingAccount looks up the account that owns control program
+// script, reusing the join LoadAccountInfo makes against
+// account_control_programs.
+func controllingAccount(ctx context.Context, script []byte) (accountID string, keyIndex [2]uint32, err error) {
+	const q = `
+		SELECT signer_id, key_index(key_index)
+		FROM account_control_programs
+		WHERE control_program = $1
+	`
+	var rawKeyIndex pg.Uint32s
+	err = pg.QueryRow(ctx, q, script).Scan(&accountID, &rawKeyIndex)
+	if err != nil {
+		return "", keyIndex, err
+	}
+	copy(keyIndex[:], rawKeyIndex)
+	return accountID, keyIndex, nil
+}
@@ -0,0 +1,65 @@
+package txbuilder
+
+import (
+	"golang.org/x/net/context"
+)
+
+// TemplateBuilder tracks the rollback callbacks of a transaction
+// under construction. Reservers that lock resources as a
+// side effect of being called (e.g. AccountReserver, which reserves
+// UTXOs) register an OnRollback callback so that a build which fails
+// partway through, or is explicitly aborted, releases whatever it
+// already reserved.
+type TemplateBuilder struct {
+	rollbacks []func()
+}
+
+// OnRollback registers fn to run if the build this builder belongs
+// to fails or is aborted. Callbacks run in the order they were
+// registered.
+func (b *TemplateBuilder) OnRollback(fn func()) {
+	b.rollbacks = append(b.rollbacks, fn)
+}
+
+// rollback runs every callback registered with OnRollback.
+func (b *TemplateBuilder) rollback() {
+	for _, fn := range b.rollbacks {
+		fn()
+	}
+}
+
+type builderContextKey int
+
+const builderKey builderContextKey = 0
+
+// NewContext returns a context carrying b, so that Reservers invoked
+// deeper in the build can find it and register rollback callbacks.
+func NewContext(ctx context.Context, b *TemplateBuilder) context.Context {
+	return context.WithValue(ctx, builderKey, b)
+}
+
+// FromContext returns the TemplateBuilder previously attached to ctx
+// with NewContext, if any.
+func FromContext(ctx context.Context) (*TemplateBuilder, bool) {
+	b, ok := ctx.Value(builderKey).(*TemplateBuilder)
+	return b, ok
+}
+
+// Build runs each of actions in order against b, stopping and
+// rolling back at the first error.
+func Build(ctx context.Context, b *TemplateBuilder, actions ...func(context.Context, *TemplateBuilder) error) error {
+	for _, action := range actions {
+		if err := action(ctx, b); err != nil {
+			b.rollback()
+			return err
+		}
+	}
+	return nil
+}
+
+// Abort runs every callback registered with OnRollback. Callers that
+// abandon a build outside of Build — for example because the
+// surrounding HTTP request was cancelled — should call it directly.
+func (b *TemplateBuilder) Abort() {
+	b.rollback()
+}
@@ -0,0 +1,46 @@
+package txbuilder
+
+import (
+	"errors"
+	"testing"
+
+	"golang.org/x/net/context"
+)
+
+func TestBuildRollsBackOnError(t *testing.T) {
+	b := new(TemplateBuilder)
+
+	var firstActionRolledBack bool
+	firstAction := func(ctx context.Context, b *TemplateBuilder) error {
+		b.OnRollback(func() { firstActionRolledBack = true })
+		return nil
+	}
+	secondAction := func(ctx context.Context, b *TemplateBuilder) error {
+		return errors.New("second action failed")
+	}
+
+	err := Build(context.Background(), b, firstAction, secondAction)
+	if err == nil {
+		t.Fatal("Build returned nil error, want the second action's error")
+	}
+	if !firstActionRolledBack {
+		t.Error("first action's rollback callback did not run after second action failed")
+	}
+}
+
+func TestBuildDoesNotRollBackOnSuccess(t *testing.T) {
+	b := new(TemplateBuilder)
+
+	var rolledBack bool
+	action := func(ctx context.Context, b *TemplateBuilder) error {
+		b.OnRollback(func() { rolledBack = true })
+		return nil
+	}
+
+	if err := Build(context.Background(), b, action, action); err != nil {
+		t.Fatalf("Build returned error: %v", err)
+	}
+	if rolledBack {
+		t.Error("rollback ran despite a successful build")
+	}
+}
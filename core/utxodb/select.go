@@ -0,0 +1,194 @@
+package utxodb
+
+import (
+	"sort"
+
+	"golang.org/x/net/context"
+
+	"chain/cos/bc"
+	"chain/database/pg"
+	"chain/errors"
+)
+
+// UTXO describes a single candidate unspent output, as returned by
+// Candidates. It carries everything a CoinSelector needs to reason
+// about a prospective input and everything Reserve needs to pin down
+// that exact output once selected.
+type UTXO struct {
+	Hash                bc.Hash
+	Index               uint32
+	AssetID             bc.AssetID
+	Amount              uint64
+	Script              []byte
+	AccountID           string
+	ControlProgramIndex [2]uint32
+}
+
+// Candidates returns the set of unreserved outputs controlling
+// assetID and owned by accountID, in no particular order. Callers
+// run a CoinSelector over the result to decide which of them to
+// actually reserve.
+func Candidates(ctx context.Context, assetID bc.AssetID, accountID string) ([]*UTXO, error) {
+	const q = `
+		SELECT acp.signer_id, key_index(acp.key_index), u.tx_hash, u.index, u.asset_id, u.amount, u.script
+		FROM utxos u
+		INNER JOIN account_control_programs acp ON acp.control_program = u.script
+		WHERE u.asset_id = $1 AND acp.signer_id = $2 AND u.reserved_until < now()
+	`
+
+	var candidates []*UTXO
+	err := pg.ForQueryRows(ctx, q, assetID, accountID, func(signerID string, keyIndex pg.Uint32s, hash bc.Hash, index uint32, assetID bc.AssetID, amount uint64, script []byte) {
+		u := &UTXO{
+			Hash:      hash,
+			Index:     index,
+			AssetID:   assetID,
+			Amount:    amount,
+			Script:    script,
+			AccountID: signerID,
+		}
+		copy(u.ControlProgramIndex[:], keyIndex)
+		candidates = append(candidates, u)
+	})
+	if err != nil {
+		return nil, errors.Wrap(err, "querying utxo candidates")
+	}
+	return candidates, nil
+}
+
+// CoinSelector chooses which of a set of candidate UTXOs to spend in
+// order to cover amount, and reports how much of the selected total
+// is left over as change. Implementations must not mutate candidates.
+type CoinSelector interface {
+	Select(candidates []*UTXO, amount uint64) (selected []*UTXO, change uint64)
+}
+
+type byAmountDesc []*UTXO
+
+func (a byAmountDesc) Len() int           { return len(a) }
+func (a byAmountDesc) Less(i, j int) bool { return a[i].Amount > a[j].Amount }
+func (a byAmountDesc) Swap(i, j int)      { a[i], a[j] = a[j], a[i] }
+
+type byAmountAsc []*UTXO
+
+func (a byAmountAsc) Len() int           { return len(a) }
+func (a byAmountAsc) Less(i, j int) bool { return a[i].Amount < a[j].Amount }
+func (a byAmountAsc) Swap(i, j int)      { a[i], a[j] = a[j], a[i] }
+
+type largestFirst struct{}
+
+// LargestFirst sorts candidates descending by amount and accumulates
+// from the top until amount is met. It's the simplest strategy and
+// matches the input-source selection used by most Bitcoin wallets.
+var LargestFirst CoinSelector = largestFirst{}
+
+func (largestFirst) Select(candidates []*UTXO, amount uint64) ([]*UTXO, uint64) {
+	sorted := append([]*UTXO(nil), candidates...)
+	sort.Sort(byAmountDesc(sorted))
+
+	var (
+		selected []*UTXO
+		total    uint64
+	)
+	for _, u := range sorted {
+		if total >= amount {
+			break
+		}
+		selected = append(selected, u)
+		total += u.Amount
+	}
+	if total < amount {
+		return nil, 0
+	}
+	return selected, total - amount
+}
+
+type smallestFirst struct{}
+
+// SmallestFirst sorts candidates ascending by amount and accumulates
+// from the bottom until amount is met, consuming as many small
+// outputs as it can along the way. It trades a larger witness for
+// consolidating dust out of an account's UTXO set.
+var SmallestFirst CoinSelector = smallestFirst{}
+
+func (smallestFirst) Select(candidates []*UTXO, amount uint64) ([]*UTXO, uint64) {
+	sorted := append([]*UTXO(nil), candidates...)
+	sort.Sort(byAmountAsc(sorted))
+
+	var (
+		selected []*UTXO
+		total    uint64
+	)
+	for _, u := range sorted {
+		if total >= amount {
+			break
+		}
+		selected = append(selected, u)
+		total += u.Amount
+	}
+	if total < amount {
+		return nil, 0
+	}
+	return selected, total - amount
+}
+
+// branchAndBoundTolerance is the effective-value slack BranchAndBound
+// will accept as an exact match, in the asset's native unit, before
+// it gives up on finding a changeless selection.
+const branchAndBoundTolerance = 100
+
+type branchAndBound struct{}
+
+// BranchAndBound searches for a subset of candidates whose total
+// falls within [amount, amount+branchAndBoundTolerance], to avoid
+// creating a change output. When it finds one it reports zero
+// change, even if the subset's total exceeds amount by up to
+// branchAndBoundTolerance. If no such subset is found within a
+// bounded number of tries, it falls back to LargestFirst.
+var BranchAndBound CoinSelector = branchAndBound{}
+
+func (branchAndBound) Select(candidates []*UTXO, amount uint64) ([]*UTXO, uint64) {
+	sorted := append([]*UTXO(nil), candidates...)
+	sort.Sort(byAmountDesc(sorted))
+
+	if selected, ok := exactMatch(sorted, amount, branchAndBoundTolerance); ok {
+		return selected, 0
+	}
+	return LargestFirst.Select(candidates, amount)
+}
+
+// exactMatch performs a depth-first branch-and-bound search over
+// candidates (each either included or excluded) for a subset whose
+// total lands in [amount, amount+tolerance]. It bails out after
+// maxTries branches to keep runtime bounded on large candidate sets.
+func exactMatch(candidates []*UTXO, amount, tolerance uint64) ([]*UTXO, bool) {
+	const maxTries = 100000
+
+	var (
+		tries int
+		best  []*UTXO
+		found bool
+	)
+
+	var search func(index int, total uint64, selected []*UTXO)
+	search = func(index int, total uint64, selected []*UTXO) {
+		if found || tries >= maxTries {
+			return
+		}
+		tries++
+
+		if total >= amount && total-amount <= tolerance {
+			best = append([]*UTXO(nil), selected...)
+			found = true
+			return
+		}
+		if index >= len(candidates) || total > amount+tolerance {
+			return
+		}
+
+		search(index+1, total+candidates[index].Amount, append(selected, candidates[index]))
+		search(index+1, total, selected)
+	}
+	search(0, 0, nil)
+
+	return best, found
+}
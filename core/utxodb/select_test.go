@@ -0,0 +1,124 @@
+package utxodb
+
+import "testing"
+
+func utxo(amount uint64) *UTXO {
+	return &UTXO{Amount: amount}
+}
+
+func totalOf(selected []*UTXO) uint64 {
+	var total uint64
+	for _, u := range selected {
+		total += u.Amount
+	}
+	return total
+}
+
+func TestLargestFirst(t *testing.T) {
+	cases := []struct {
+		name       string
+		candidates []uint64
+		amount     uint64
+		wantCount  int
+		wantChange uint64
+	}{
+		{"insufficient funds", []uint64{10, 20}, 100, 0, 0},
+		{"exact match", []uint64{30, 20, 10}, 30, 1, 0},
+		{"accumulates from largest", []uint64{5, 40, 10}, 45, 2, 5},
+		{"empty candidates", nil, 1, 0, 0},
+	}
+	for _, c := range cases {
+		var candidates []*UTXO
+		for _, a := range c.candidates {
+			candidates = append(candidates, utxo(a))
+		}
+		selected, change := LargestFirst.Select(candidates, c.amount)
+		if len(selected) != c.wantCount {
+			t.Errorf("%s: len(selected) = %d, want %d", c.name, len(selected), c.wantCount)
+		}
+		if change != c.wantChange {
+			t.Errorf("%s: change = %d, want %d", c.name, change, c.wantChange)
+		}
+		if c.wantCount > 0 && totalOf(selected) != c.amount+c.wantChange {
+			t.Errorf("%s: selected total = %d, want %d", c.name, totalOf(selected), c.amount+c.wantChange)
+		}
+	}
+}
+
+func TestSmallestFirst(t *testing.T) {
+	cases := []struct {
+		name       string
+		candidates []uint64
+		amount     uint64
+		wantCount  int
+		wantChange uint64
+	}{
+		{"insufficient funds", []uint64{10, 20}, 100, 0, 0},
+		{"exact match", []uint64{10, 20, 30}, 30, 2, 0},
+		{"consumes dust first", []uint64{1, 2, 40}, 3, 2, 0},
+	}
+	for _, c := range cases {
+		var candidates []*UTXO
+		for _, a := range c.candidates {
+			candidates = append(candidates, utxo(a))
+		}
+		selected, change := SmallestFirst.Select(candidates, c.amount)
+		if len(selected) != c.wantCount {
+			t.Errorf("%s: len(selected) = %d, want %d", c.name, len(selected), c.wantCount)
+		}
+		if change != c.wantChange {
+			t.Errorf("%s: change = %d, want %d", c.name, change, c.wantChange)
+		}
+	}
+}
+
+func TestBranchAndBoundExactMatch(t *testing.T) {
+	candidates := []uint64{5, 10, 15, 25}
+	var utxos []*UTXO
+	for _, a := range candidates {
+		utxos = append(utxos, utxo(a))
+	}
+
+	selected, change := BranchAndBound.Select(utxos, 25)
+	if change != 0 {
+		t.Errorf("change = %d, want 0 for an exact changeless match", change)
+	}
+	if got := totalOf(selected); got != 25 {
+		t.Errorf("selected total = %d, want 25", got)
+	}
+}
+
+func TestBranchAndBoundWithinTolerance(t *testing.T) {
+	utxos := []*UTXO{utxo(1030)}
+
+	selected, change := BranchAndBound.Select(utxos, 1000)
+	if change != 0 {
+		t.Errorf("change = %d, want 0 for a match within tolerance", change)
+	}
+	if len(selected) != 1 {
+		t.Fatalf("len(selected) = %d, want 1", len(selected))
+	}
+}
+
+func TestBranchAndBoundFallsBackToLargestFirst(t *testing.T) {
+	// No subset of these candidates lands within tolerance of amount,
+	// so BranchAndBound must fall back to LargestFirst and report the
+	// resulting change.
+	utxos := []*UTXO{utxo(1000), utxo(2000), utxo(3000)}
+
+	selected, change := BranchAndBound.Select(utxos, 1)
+	wantSelected, wantChange := LargestFirst.Select(utxos, 1)
+	if len(selected) != len(wantSelected) || change != wantChange {
+		t.Errorf("got (selected=%v, change=%d), want fallback result (selected=%v, change=%d)",
+			selected, change, wantSelected, wantChange)
+	}
+}
+
+func TestBranchAndBoundInsufficientFunds(t *testing.T) {
+	utxos := []*UTXO{utxo(1), utxo(2)}
+
+	selected, change := BranchAndBound.Select(utxos, 100)
+	if selected != nil || change != 0 {
+		t.Errorf("got (selected=%v, change=%d), want (nil, 0) for insufficient funds", selected, change)
+	}
+}
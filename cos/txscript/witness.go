@@ -0,0 +1,35 @@
+package txscript
+
+import (
+	"crypto/sha256"
+
+	"chain/crypto/ed25519"
+)
+
+// witnessVersion0 tags a control program as a version-0 witness
+// program, mirroring the segwit convention of committing to a hash
+// of the spending condition rather than embedding it directly.
+const witnessVersion0 = 0x00
+
+// WitnessPubKeyScript returns a compact control program for the
+// common 1-of-1 case: a single push of the witness version followed
+// by the SHA-256 of pubkey. It replaces an m-of-n MultiSigScript
+// (quorum push, pubkey pushes, n push, OP_CHECKMULTISIG) with a
+// single hash push, so spending it only requires one witness
+// signature plus the pubkey itself, instead of an m-of-n redeem
+// script and its wrapper.
+func WitnessPubKeyScript(pubkey ed25519.PublicKey) ([]byte, error) {
+	h := sha256.Sum256(pubkey)
+
+	script := make([]byte, 0, 2+len(h))
+	script = append(script, witnessVersion0)
+	script = append(script, byte(len(h)))
+	script = append(script, h[:]...)
+	return script, nil
+}
+
+// IsWitnessPubKeyScript reports whether program looks like a script
+// produced by WitnessPubKeyScript.
+func IsWitnessPubKeyScript(program []byte) bool {
+	return len(program) == 2+sha256.Size && program[0] == witnessVersion0 && program[1] == sha256.Size
+}
@@ -0,0 +1,54 @@
+package txscript
+
+import (
+	"bytes"
+	"testing"
+
+	"chain/crypto/ed25519"
+)
+
+func TestWitnessPubKeyScript(t *testing.T) {
+	pubkey := ed25519.PublicKey(bytes.Repeat([]byte{0x01}, ed25519.PublicKeySize))
+
+	script, err := WitnessPubKeyScript(pubkey)
+	if err != nil {
+		t.Fatalf("WitnessPubKeyScript returned error: %v", err)
+	}
+	if len(script) != 34 {
+		t.Fatalf("len(script) = %d, want 34", len(script))
+	}
+	if script[0] != witnessVersion0 {
+		t.Errorf("script[0] = %x, want witness version %x", script[0], witnessVersion0)
+	}
+	if script[1] != 32 {
+		t.Errorf("script[1] = %d, want 32 (hash length)", script[1])
+	}
+	if !IsWitnessPubKeyScript(script) {
+		t.Error("IsWitnessPubKeyScript(script) = false, want true")
+	}
+}
+
+func TestIsWitnessPubKeyScript(t *testing.T) {
+	pubkey := ed25519.PublicKey(bytes.Repeat([]byte{0x02}, ed25519.PublicKeySize))
+	script, err := WitnessPubKeyScript(pubkey)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	cases := []struct {
+		name    string
+		program []byte
+		want    bool
+	}{
+		{"valid witness-pubkey script", script, true},
+		{"wrong version byte", append([]byte{0x01}, script[1:]...), false},
+		{"wrong length push", append([]byte{script[0], 31}, script[2:]...), false},
+		{"too short", script[:10], false},
+		{"nil program", nil, false},
+	}
+	for _, c := range cases {
+		if got := IsWitnessPubKeyScript(c.program); got != c.want {
+			t.Errorf("%s: IsWitnessPubKeyScript = %v, want %v", c.name, got, c.want)
+		}
+	}
+}
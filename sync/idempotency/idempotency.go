@@ -0,0 +1,125 @@
+// Package idempotency provides a singleflight-style Group that, in
+// addition to collapsing concurrent duplicate calls, caches the
+// result of a completed call for a bounded TTL so that a retry of
+// the same call shortly after the original completed gets back the
+// original result rather than doing the work again.
+package idempotency
+
+import (
+	"container/list"
+	"sync"
+	"time"
+)
+
+// call represents an in-flight or completed call tracked by a Group.
+type call struct {
+	wg  sync.WaitGroup
+	val interface{}
+	err error
+
+	expires time.Time
+	elem    *list.Element // this call's node in Group.order, once ready
+}
+
+// Group caches the result of calls keyed by an arbitrary string,
+// collapsing concurrent callers sharing a key into a single
+// invocation of fn and serving a cached result to anyone calling
+// again with the same key before it expires.
+//
+// The zero value is not usable; use New.
+type Group struct {
+	ttl      time.Duration
+	capacity int
+
+	mu    sync.Mutex
+	calls map[string]*call
+	order *list.List // least-recently-completed in front
+}
+
+// New returns a Group whose cached results expire after ttl and
+// which evicts the oldest completed entry once more than capacity
+// completed entries are cached.
+func New(ttl time.Duration, capacity int) *Group {
+	return &Group{
+		ttl:      ttl,
+		capacity: capacity,
+		calls:    make(map[string]*call),
+		order:    list.New(),
+	}
+}
+
+// Do executes and returns the results of fn, making sure that only
+// one execution is in flight for a given key at a time. If a
+// duplicate call for key comes in while fn is running, the duplicate
+// waits for the original to complete and shares its result. If a
+// call for key comes in after fn has already completed and before
+// the TTL has elapsed, it receives the cached result without running
+// fn again. A call whose fn returned an error is not cached, so the
+// next call for key runs fn again. The returned bool reports whether the result came from
+// an existing call (shared or cached) rather than a fresh invocation.
+func (g *Group) Do(key string, fn func() (interface{}, error)) (val interface{}, err error, shared bool) {
+	g.mu.Lock()
+	g.evictExpiredLocked()
+
+	if c, ok := g.calls[key]; ok {
+		g.mu.Unlock()
+		c.wg.Wait()
+		return c.val, c.err, true
+	}
+
+	c := new(call)
+	c.wg.Add(1)
+	g.calls[key] = c
+	g.mu.Unlock()
+
+	c.val, c.err = fn()
+	c.wg.Done()
+
+	g.mu.Lock()
+	if c.err != nil {
+		// Don't cache a failed call: a transient error (a dropped DB
+		// connection, a momentary insufficient-funds race) shouldn't be
+		// replayed to every retry for the rest of the TTL. Drop it now
+		// so the next call with this key actually retries fn.
+		delete(g.calls, key)
+	} else {
+		c.expires = time.Now().Add(g.ttl)
+		c.elem = g.order.PushBack(key)
+		g.evictOverCapacityLocked()
+	}
+	g.mu.Unlock()
+
+	return c.val, c.err, false
+}
+
+// evictExpiredLocked removes calls whose TTL has elapsed. g.mu must
+// be held.
+func (g *Group) evictExpiredLocked() {
+	now := time.Now()
+	for e := g.order.Front(); e != nil; {
+		key := e.Value.(string)
+		c := g.calls[key]
+		if c == nil || !c.expires.After(now) {
+			next := e.Next()
+			g.order.Remove(e)
+			delete(g.calls, key)
+			e = next
+			continue
+		}
+		break // order is oldest-first, so the rest are still live
+	}
+}
+
+// evictOverCapacityLocked removes the oldest completed calls until
+// the Group holds at most g.capacity of them. g.mu must be held.
+func (g *Group) evictOverCapacityLocked() {
+	if g.capacity <= 0 {
+		return
+	}
+	for g.order.Len() > g.capacity {
+		front := g.order.Front()
+		key := front.Value.(string)
+		g.order.Remove(front)
+		delete(g.calls, key)
+	}
+}
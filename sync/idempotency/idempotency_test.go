@@ -0,0 +1,147 @@
+package idempotency
+
+import (
+	"errors"
+	"sync"
+	"sync/atomic"
+	"testing"
+	"time"
+)
+
+var errFirstCallFails = errors.New("first call fails")
+
+func TestDoCollapsesConcurrentCallers(t *testing.T) {
+	g := New(time.Minute, 100)
+
+	var calls int32
+	const n = 50
+
+	var wg sync.WaitGroup
+	results := make([]int, n)
+	wg.Add(n)
+	start := make(chan struct{})
+	for i := 0; i < n; i++ {
+		go func(i int) {
+			defer wg.Done()
+			<-start
+			val, err, _ := g.Do("key", func() (interface{}, error) {
+				atomic.AddInt32(&calls, 1)
+				time.Sleep(10 * time.Millisecond)
+				return 42, nil
+			})
+			if err != nil {
+				t.Errorf("unexpected error: %v", err)
+			}
+			results[i] = val.(int)
+		}(i)
+	}
+	close(start)
+	wg.Wait()
+
+	if got := atomic.LoadInt32(&calls); got != 1 {
+		t.Errorf("fn called %d times, want 1", got)
+	}
+	for i, r := range results {
+		if r != 42 {
+			t.Errorf("results[%d] = %d, want 42", i, r)
+		}
+	}
+}
+
+func TestDoCachesCompletedResultWithinTTL(t *testing.T) {
+	g := New(time.Minute, 100)
+
+	var calls int32
+	call := func() (interface{}, error) {
+		atomic.AddInt32(&calls, 1)
+		return "first", nil
+	}
+
+	val, _, shared := g.Do("key", call)
+	if shared {
+		t.Errorf("first Do reported shared = true")
+	}
+	if val != "first" {
+		t.Errorf("val = %v, want \"first\"", val)
+	}
+
+	val, _, shared = g.Do("key", call)
+	if !shared {
+		t.Errorf("second Do reported shared = false, want cached hit")
+	}
+	if val != "first" {
+		t.Errorf("cached val = %v, want \"first\"", val)
+	}
+	if got := atomic.LoadInt32(&calls); got != 1 {
+		t.Errorf("fn called %d times, want 1", got)
+	}
+}
+
+func TestDoDoesNotCacheFailure(t *testing.T) {
+	g := New(time.Minute, 100)
+
+	var calls int32
+	failingCall := func() (interface{}, error) {
+		atomic.AddInt32(&calls, 1)
+		return nil, errFirstCallFails
+	}
+
+	_, err, shared := g.Do("key", failingCall)
+	if err != errFirstCallFails {
+		t.Fatalf("err = %v, want errFirstCallFails", err)
+	}
+	if shared {
+		t.Errorf("first Do reported shared = true")
+	}
+
+	val, err, shared := g.Do("key", func() (interface{}, error) {
+		atomic.AddInt32(&calls, 1)
+		return "recovered", nil
+	})
+	if shared {
+		t.Errorf("retry after a failure reported shared = true, want a fresh call")
+	}
+	if err != nil {
+		t.Fatalf("retry returned error: %v", err)
+	}
+	if val != "recovered" {
+		t.Errorf("val = %v, want \"recovered\"", val)
+	}
+	if got := atomic.LoadInt32(&calls); got != 2 {
+		t.Errorf("fn called %d times, want 2 (failed result must not be cached)", got)
+	}
+}
+
+func TestDoExpiresAfterTTL(t *testing.T) {
+	g := New(10*time.Millisecond, 100)
+
+	var calls int32
+	call := func() (interface{}, error) {
+		atomic.AddInt32(&calls, 1)
+		return "v", nil
+	}
+
+	g.Do("key", call)
+	time.Sleep(30 * time.Millisecond)
+	g.Do("key", call)
+
+	if got := atomic.LoadInt32(&calls); got != 2 {
+		t.Errorf("fn called %d times, want 2 after TTL expiry", got)
+	}
+}
+
+func TestEvictsOverCapacity(t *testing.T) {
+	g := New(time.Minute, 2)
+
+	for i := 0; i < 3; i++ {
+		key := string(rune('a' + i))
+		g.Do(key, func() (interface{}, error) { return key, nil })
+	}
+
+	g.mu.Lock()
+	n := len(g.calls)
+	g.mu.Unlock()
+	if n != 2 {
+		t.Errorf("cached entries = %d, want 2", n)
+	}
+}